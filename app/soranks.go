@@ -1,13 +1,19 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"io/ioutil"
 	"os"
-	"regexp"
+	"os/signal"
+	"path/filepath"
+	"sync/atomic"
+	"syscall"
+	"time"
 
 	"github.com/klashxx/soranks/lib"
+	pb "gopkg.in/cheggaaa/pb.v1"
 )
 
 const (
@@ -18,22 +24,53 @@ const (
 	GitHubToken   = "../_secret/token"
 	SOApiURL      = "https://api.stackexchange.com/2.2"
 	SOUsersQuery  = `users?page=%d&pagesize=100&order=desc&sort=reputation&site=stackoverflow`
-	SOUserTags    = `users/%d/top-answer-tags?page=1&pagesize=3&site=stackoverflow`
-	GHApiURL      = "https://api.github.com/repos/klashxx/soranks"
+	GHOwner       = "klashxx"
+	GHRepo        = "soranks"
 )
 
 var (
-	author   = lib.Committer{Name: "klasxx", Email: "klashxx@gmail.com"}
-	branch   = "dev"
-	location = flag.String("location", ".", "location")
-	jsonfile = flag.String("json", "", "json sample file")
-	jsonrsp  = flag.String("jsonrsp", "", "json response file")
-	mdrsp    = flag.String("mdrsp", "", "markdown response file")
-	limit    = flag.Int("limit", 20, "max number of records")
-	term     = flag.Bool("term", false, "print output in terminal")
-	publish  = flag.String("publish", "", "publish ranks in Github")
+	author     = lib.Committer{Name: "klasxx", Email: "klashxx@gmail.com"}
+	branch     = "dev"
+	location   = flag.String("location", ".", "location")
+	jsonfile   = flag.String("json", "", "json sample file")
+	jsonrsp    = flag.String("jsonrsp", "", "json response file")
+	mdrsp      = flag.String("mdrsp", "", "markdown response file")
+	filterExpr = flag.String("filter", "", "filter expression, e.g. \"badges.gold >= 5 && reputation_change_year >= 10000\"; combines with -location")
+	limit      = flag.Int("limit", 20, "max number of records")
+	term       = flag.Bool("term", false, "print output in terminal")
+	publish    = flag.String("publish", "", "publish ranks in Github")
+	workers    = flag.Int("workers", 8, "concurrent page fetchers")
+	tags       = flag.Bool("tags", true, "enrich ranks with each user's top answer tags")
+
+	cachedir     = flag.String("cachedir", defaultCacheDir(), "on-disk HTTP cache directory")
+	cacheTTL     = flag.Duration("cache-ttl", 10*time.Minute, "cache entry freshness window")
+	cacheRefresh = flag.Bool("cache-refresh", false, "revalidate every cached entry with a conditional GET")
+	noCache      = flag.Bool("no-cache", false, "disable the on-disk HTTP cache")
+
+	reqTimeout   = flag.Duration("req-timeout", 30*time.Second, "timeout for each crawled page request (0 disables); top-tags, GitHub and S3 requests are not bounded by it")
+	totalTimeout = flag.Duration("total-timeout", 0, "deadline for the whole run (0 disables)")
+
+	pr      = flag.Bool("pr", false, "open a pull request for the published report")
+	prBase  = flag.String("pr-base", "main", "base branch for -pr")
+	release = flag.Bool("release", false, "cut a tagged GitHub release from the published report")
+
+	s3Endpoint  = flag.String("s3-endpoint", "", "S3-compatible endpoint to upload reports to (enables the S3 sink)")
+	s3Bucket    = flag.String("s3-bucket", "", "S3 bucket for -s3-endpoint")
+	s3Prefix    = flag.String("s3-prefix", "", "key prefix for reports uploaded to -s3-bucket")
+	s3Region    = flag.String("s3-region", "", "S3 region for -s3-endpoint")
+	s3AccessKey = flag.String("s3-access-key", "", "S3 access key")
+	s3SecretKey = flag.String("s3-secret-key", "", "S3 secret key")
+	s3Public    = flag.Bool("s3-public", false, "mark uploaded reports public-read")
 )
 
+func defaultCacheDir() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ".soranks/cache"
+	}
+	return filepath.Join(home, ".soranks", "cache")
+}
+
 func main() {
 	flag.Parse()
 	lib.Init(ioutil.Discard, os.Stdout, os.Stdout, os.Stderr)
@@ -52,68 +89,114 @@ func main() {
 		os.Exit(5)
 	}
 
-	re := regexp.MustCompile(fmt.Sprintf("(?i)%s", *location))
+	if !*noCache {
+		if err := lib.InitCache(*cachedir, *cacheTTL, *cacheRefresh); err != nil {
+			lib.Warning.Printf("Can't enable HTTP cache: %s\n", err)
+		}
+	}
 
-	stop := false
-	streamErrors := 0
-	currentPage := 1
-	lastPage := currentPage
-	counter := 0
+	locFilter, err := lib.ParseFilter(fmt.Sprintf("location ~= /%s/", *location))
+	if err != nil {
+		lib.Error.Printf("Bad -location: %s\n", err)
+		os.Exit(5)
+	}
+	userFilter, err := lib.ParseFilter(*filterExpr)
+	if err != nil {
+		lib.Error.Printf("Bad -filter: %s\n", err)
+		os.Exit(5)
+	}
+	filter := lib.And(locFilter, userFilter)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		sig := <-sigs
+		lib.Warning.Printf("Received %s, cancelling run and dumping partial results.\n", sig)
+		cancel()
+	}()
+
+	if *totalTimeout > 0 {
+		var total lib.DeadlineTimer
+		ctx, cancel = lib.WithDeadlineTimer(ctx, &total, *totalTimeout)
+		defer cancel()
+	}
 
-	var users *lib.SOUsers
 	var ranks lib.Ranks
-	var key string
-	var err error
-
-	for {
-		if *jsonfile == "" {
-			if lastPage == currentPage {
-				lib.Info.Println("Trying to extract API key.")
-				key = fmt.Sprintf("&key=%s", lib.GetKey(APIKeyPath))
-			}
+	var lastPage int
+	counter := 0
 
-			lib.Trace.Printf("Requesting page: %d\n", currentPage)
+	if *jsonfile == "" {
+		lib.Info.Println("Trying to extract API key.")
+		key := lib.GetKey(APIKeyPath)
 
-			url := fmt.Sprintf("%s/%s%s", SOApiURL, fmt.Sprintf(SOUsersQuery, currentPage), key)
+		lib.Trace.Printf("Crawling up to %d pages with %d workers.\n", MaxPages, *workers)
 
-			users = new(lib.SOUsers)
+		crawler := lib.NewCrawler(SOApiURL, SOUsersQuery, key, *workers, MinReputation)
+		crawler.ReqTimeout = *reqTimeout
+		crawler.MaxPageRetries = MaxErrors
 
-			err = lib.StreamHTTP(url, users, true)
+		bar := pb.New(MaxPages)
+		bar.ShowSpeed = true
+		bar.ManualUpdate = true
+		bar.SetWidth(78)
+		bar.Start()
 
-			lib.Trace.Printf("Page users: %d\n", len(users.Items))
-			if err != nil || len(users.Items) == 0 {
+		var progressPage int64
+		crawler.Progress = func(page, maxPages int) {
+			atomic.StoreInt64(&progressPage, int64(page))
+		}
 
-				lib.Warning.Println("Can't stream data.")
-				streamErrors += 1
-				if streamErrors >= MaxErrors {
-					lib.Error.Println("Max retry number reached")
-					os.Exit(5)
+		ticker := time.NewTicker(200 * time.Millisecond)
+		tickerDone := make(chan struct{})
+		go func() {
+			for {
+				select {
+				case <-ticker.C:
+					bar.Set(int(atomic.LoadInt64(&progressPage)))
+					bar.Update()
+				case <-tickerDone:
+					return
 				}
-				continue
-			}
-		} else {
-			lib.Info.Println("Extracting from source JSON file.")
-			var err error
-			users, err = lib.StreamFile(*jsonfile)
-			if err != nil {
-				lib.Error.Println("Can't decode json file.")
-				os.Exit(5)
 			}
-			stop = true
-		}
+		}()
 
-		lib.Trace.Println("User info extraction.")
+		ranks, lastPage, _ = crawler.Run(ctx, MaxPages, filter, *limit, *term)
 
-		repLimit := lib.GetUserInfo(users, MinReputation, re, &counter, *limit, &ranks, *term)
-		if !repLimit {
-			break
+		ticker.Stop()
+		close(tickerDone)
+		bar.Set(lastPage)
+		bar.Update()
+		bar.Finish()
+
+		counter = len(ranks)
+
+		if *tags {
+			lib.Trace.Println("Enriching ranks with top answer tags.")
+			lib.EnrichTopTags(ctx, ranks, key, *workers, *term)
+		}
+	} else {
+		lib.Info.Println("Extracting from source JSON file.")
+		users, err := lib.StreamFileCtx(ctx, *jsonfile)
+		if err != nil {
+			lib.Error.Println("Can't decode json file.")
+			os.Exit(5)
 		}
-		lib.Trace.Println("User info extraction done.")
 
-		lastPage = currentPage
-		currentPage += 1
-		if (currentPage >= MaxPages && MaxPages != 0) || !users.HasMore || stop {
-			break
+		lib.Trace.Println("User info extraction.")
+		lib.GetUserInfoCtx(ctx, users, MinReputation, filter, &counter, *limit, &ranks, *term)
+		lastPage = 1
+
+		if *tags {
+			key := lib.GetKey(APIKeyPath)
+			if key == "" {
+				lib.Warning.Println("No API key available, skipping top answer tags enrichment.")
+			} else {
+				lib.Trace.Println("Enriching ranks with top answer tags.")
+				lib.EnrichTopTags(ctx, ranks, key, *workers, *term)
+			}
 		}
 	}
 
@@ -122,17 +205,67 @@ func main() {
 		os.Exit(0)
 	}
 
+	var sink lib.Sink = lib.FileSink{}
+	if *s3Endpoint != "" || *s3Bucket != "" {
+		s3sink, err := lib.NewS3Sink(*s3Endpoint, *s3Region, *s3Bucket, *s3Prefix, *s3AccessKey, *s3SecretKey, *s3Public)
+		if err != nil {
+			lib.Error.Printf("Can't set up S3 sink: %s\n", err)
+			os.Exit(5)
+		}
+		sink = s3sink
+	}
+
 	if *jsonrsp != "" {
-		lib.DumpJson(jsonrsp, &ranks)
+		if err := lib.DumpJsonSink(ctx, sink, *jsonrsp, &ranks); err != nil {
+			lib.Error.Printf("Can't write %s: %s\n", *jsonrsp, err)
+			os.Exit(5)
+		}
 	}
 
 	if *mdrsp != "" {
-		lib.DumpMarkdown(mdrsp, ranks, location)
+		content, reportURL, err := lib.DumpMarkdownSink(ctx, sink, *mdrsp, ranks, location)
+		if err != nil {
+			lib.Error.Printf("Can't write %s: %s\n", *mdrsp, err)
+			os.Exit(5)
+		}
+
 		if *publish != "" {
-			_ = lib.GitHubConnector(GHApiURL, *publish, *mdrsp, GitHubToken, branch, author)
+			publisher, err := lib.NewGitHubPublisher(ctx, GHOwner, GHRepo, branch, GitHubToken, author)
+			if err != nil {
+				lib.Error.Printf("Can't set up GitHub publisher: %s\n", err)
+				os.Exit(5)
+			}
+			publisher.PR = *pr
+			publisher.PRBase = *prBase
+			publisher.Release = *release
+
+			publishContent := content
+			if *release && reportURL != "" {
+				publishContent = append(publishContent, []byte(fmt.Sprintf("\nUploaded report: %s\n", reportURL))...)
+			}
+
+			result, err := publisher.Publish(ctx, *publish, string(publishContent))
+			if err != nil {
+				lib.Error.Printf("Can't publish to GitHub: %s\n", err)
+				os.Exit(5)
+			} else {
+				lib.Info.Printf("Published commit: %s\n", result.CommitURL)
+				if result.PullRequestURL != "" {
+					lib.Info.Printf("Opened pull request: %s\n", result.PullRequestURL)
+				}
+				if result.ReleaseURL != "" {
+					lib.Info.Printf("Cut release: %s\n", result.ReleaseURL)
+				}
+			}
 		}
 	}
 
 	lib.Info.Printf("%04d pages requested.\n", lastPage)
 	lib.Info.Printf("%04d users found.\n", counter)
-}
\ No newline at end of file
+
+	if lib.CacheEnabled() {
+		stats := lib.GetCacheStats()
+		lib.Info.Printf("Cache: %d hits, %d misses, %d not-modified, %d bytes saved.\n",
+			stats.Hits, stats.Misses, stats.NotModified, stats.BytesSaved)
+	}
+}
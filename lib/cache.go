@@ -0,0 +1,307 @@
+package lib
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CacheEntry is the on-disk representation of one cached response, enough
+// to both serve it again and drive the next conditional GET.
+type CacheEntry struct {
+	// Body is stored exactly as received on the wire — still gzip-encoded
+	// when ContentEncoding is "gzip" — and replayed through response()
+	// with the same Content-Encoding header, so StreamHTTPCtx's existing
+	// gzip handling inflates it on a cache hit exactly as it would on a
+	// live fetch.
+	Body            []byte
+	StatusCode      int
+	ContentEncoding string
+	ETag            string
+	LastModified    string
+	MaxAge          time.Duration
+	Backoff         int
+	StoredAt        time.Time
+}
+
+func (e *CacheEntry) fresh(ttl time.Duration) bool {
+	age := time.Since(e.StoredAt)
+	if e.MaxAge > 0 && age >= e.MaxAge {
+		return false
+	}
+	if ttl > 0 && age >= ttl {
+		return false
+	}
+	return true
+}
+
+func (e *CacheEntry) response() *http.Response {
+	header := make(http.Header)
+	if e.ContentEncoding != "" {
+		header.Set("Content-Encoding", e.ContentEncoding)
+	}
+	return &http.Response{
+		StatusCode:    e.StatusCode,
+		Header:        header,
+		Body:          ioutil.NopCloser(bytes.NewReader(e.Body)),
+		ContentLength: int64(len(e.Body)),
+	}
+}
+
+// CacheStats tallies how effective the on-disk cache was over a run.
+type CacheStats struct {
+	Hits        int
+	Misses      int
+	NotModified int
+	BytesSaved  int64
+}
+
+type refCountedMutex struct {
+	mu    sync.Mutex
+	count int
+}
+
+// Cache is an on-disk HTTP response cache keyed by sha256(url-without-key).
+type Cache struct {
+	Dir     string
+	TTL     time.Duration
+	Refresh bool
+
+	mu    sync.Mutex
+	locks map[string]*refCountedMutex
+	stats CacheStats
+}
+
+// NewCache creates a Cache rooted at dir, creating the directory if needed.
+func NewCache(dir string, ttl time.Duration, refresh bool) (*Cache, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &Cache{Dir: dir, TTL: ttl, Refresh: refresh, locks: make(map[string]*refCountedMutex)}, nil
+}
+
+// Stats returns a snapshot of the cache's hit/miss counters.
+func (c *Cache) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.stats
+}
+
+func cacheKey(rawurl string) string {
+	key := rawurl
+	if u, err := url.Parse(rawurl); err == nil {
+		q := u.Query()
+		q.Del("key")
+		u.RawQuery = q.Encode()
+		key = u.String()
+	}
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+func (c *Cache) path(key string) string {
+	return filepath.Join(c.Dir, key)
+}
+
+// acquire returns the per-key mutex, creating and ref-counting it, so
+// concurrent workers hitting the same URL serialize on the same lock
+// instead of racing to populate the cache file.
+func (c *Cache) acquire(key string) *refCountedMutex {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	l, ok := c.locks[key]
+	if !ok {
+		l = &refCountedMutex{}
+		c.locks[key] = l
+	}
+	l.count++
+	return l
+}
+
+func (c *Cache) release(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if l, ok := c.locks[key]; ok {
+		l.count--
+		if l.count == 0 {
+			delete(c.locks, key)
+		}
+	}
+}
+
+func (c *Cache) load(key string) (*CacheEntry, bool) {
+	f, err := os.Open(c.path(key))
+	if err != nil {
+		return nil, false
+	}
+	defer f.Close()
+
+	entry := new(CacheEntry)
+	if err := gob.NewDecoder(f).Decode(entry); err != nil {
+		return nil, false
+	}
+	return entry, true
+}
+
+func (c *Cache) store(key string, entry *CacheEntry) {
+	f, err := os.Create(c.path(key))
+	if err != nil {
+		Warning.Printf("Can't write cache entry %s: %s\n", key, err)
+		return
+	}
+	defer f.Close()
+
+	if err := gob.NewEncoder(f).Encode(entry); err != nil {
+		Warning.Printf("Can't encode cache entry %s: %s\n", key, err)
+	}
+}
+
+func maxAge(cacheControl string) time.Duration {
+	for _, directive := range strings.Split(cacheControl, ",") {
+		directive = strings.TrimSpace(directive)
+		if !strings.HasPrefix(directive, "max-age=") {
+			continue
+		}
+		seconds, err := strconv.Atoi(strings.TrimPrefix(directive, "max-age="))
+		if err != nil {
+			continue
+		}
+		return time.Duration(seconds) * time.Second
+	}
+	return 0
+}
+
+// CachingTransport is an http.RoundTripper that serves GET requests from a
+// Cache when the cached entry is still fresh, revalidates it with a
+// conditional GET (If-None-Match / If-Modified-Since) otherwise, and falls
+// through to Next unchanged for anything it can't cache.
+type CachingTransport struct {
+	Next  http.RoundTripper
+	Cache *Cache
+}
+
+func (t *CachingTransport) next() http.RoundTripper {
+	if t.Next != nil {
+		return t.Next
+	}
+	return http.DefaultTransport
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *CachingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method != http.MethodGet {
+		return t.next().RoundTrip(req)
+	}
+
+	key := cacheKey(req.URL.String())
+	lock := t.Cache.acquire(key)
+	lock.mu.Lock()
+	defer func() {
+		lock.mu.Unlock()
+		t.Cache.release(key)
+	}()
+
+	entry, ok := t.Cache.load(key)
+	if ok && !t.Cache.Refresh && entry.fresh(t.Cache.TTL) {
+		t.Cache.mu.Lock()
+		t.Cache.stats.Hits++
+		t.Cache.stats.BytesSaved += int64(len(entry.Body))
+		t.Cache.mu.Unlock()
+		return entry.response(), nil
+	}
+
+	if ok {
+		if entry.ETag != "" {
+			req.Header.Set("If-None-Match", entry.ETag)
+		}
+		if entry.LastModified != "" {
+			req.Header.Set("If-Modified-Since", entry.LastModified)
+		}
+	}
+
+	resp, err := t.next().RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if ok && resp.StatusCode == http.StatusNotModified {
+		resp.Body.Close()
+		t.Cache.mu.Lock()
+		t.Cache.stats.NotModified++
+		t.Cache.stats.BytesSaved += int64(len(entry.Body))
+		t.Cache.mu.Unlock()
+		return entry.response(), nil
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	backoff := 0
+	if b := resp.Header.Get("backoff"); b != "" {
+		backoff, _ = strconv.Atoi(b)
+	}
+
+	newEntry := &CacheEntry{
+		Body:            body,
+		StatusCode:      resp.StatusCode,
+		ContentEncoding: resp.Header.Get("Content-Encoding"),
+		ETag:            resp.Header.Get("ETag"),
+		LastModified:    resp.Header.Get("Last-Modified"),
+		MaxAge:          maxAge(resp.Header.Get("Cache-Control")),
+		Backoff:         backoff,
+		StoredAt:        time.Now(),
+	}
+	t.Cache.store(key, newEntry)
+
+	t.Cache.mu.Lock()
+	t.Cache.stats.Misses++
+	t.Cache.mu.Unlock()
+
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+	resp.ContentLength = int64(len(body))
+	return resp, nil
+}
+
+var httpClient = http.DefaultClient
+
+// InitCache enables the on-disk HTTP cache for every subsequent StreamHTTP
+// call, rooted at dir with the given freshness TTL. Passing refresh=true
+// forces a conditional GET on every request, skipping the freshness check
+// but still saving quota on unmodified pages.
+func InitCache(dir string, ttl time.Duration, refresh bool) error {
+	cache, err := NewCache(dir, ttl, refresh)
+	if err != nil {
+		return err
+	}
+	httpClient = &http.Client{Transport: &CachingTransport{Cache: cache}}
+	return nil
+}
+
+// CacheEnabled reports whether InitCache has configured an on-disk cache.
+func CacheEnabled() bool {
+	_, ok := httpClient.Transport.(*CachingTransport)
+	return ok
+}
+
+// GetCacheStats returns the active cache's hit/miss counters, or a zero
+// CacheStats when caching is disabled.
+func GetCacheStats() CacheStats {
+	t, ok := httpClient.Transport.(*CachingTransport)
+	if !ok {
+		return CacheStats{}
+	}
+	return t.Cache.Stats()
+}
@@ -0,0 +1,113 @@
+package lib
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCacheKeyIgnoresAPIKey(t *testing.T) {
+	a := cacheKey("https://api.stackexchange.com/2.2/users?page=1&key=secret1")
+	b := cacheKey("https://api.stackexchange.com/2.2/users?page=1&key=secret2")
+	if a != b {
+		t.Errorf("cacheKey should ignore the key param, got %q and %q", a, b)
+	}
+
+	c := cacheKey("https://api.stackexchange.com/2.2/users?page=2&key=secret1")
+	if a == c {
+		t.Errorf("cacheKey should vary with page, got the same key for both")
+	}
+}
+
+func TestMaxAge(t *testing.T) {
+	cases := []struct {
+		header string
+		want   time.Duration
+	}{
+		{"public, max-age=300", 300 * time.Second},
+		{"max-age=0", 0},
+		{"no-cache", 0},
+		{"", 0},
+	}
+	for _, c := range cases {
+		if got := maxAge(c.header); got != c.want {
+			t.Errorf("maxAge(%q) = %s, want %s", c.header, got, c.want)
+		}
+	}
+}
+
+func TestCacheEntryFresh(t *testing.T) {
+	e := &CacheEntry{StoredAt: time.Now().Add(-10 * time.Second), MaxAge: 5 * time.Second}
+	if e.fresh(0) {
+		t.Error("entry past its MaxAge should not be fresh")
+	}
+
+	e = &CacheEntry{StoredAt: time.Now(), MaxAge: time.Minute}
+	if !e.fresh(0) {
+		t.Error("entry within its MaxAge should be fresh")
+	}
+
+	e = &CacheEntry{StoredAt: time.Now().Add(-time.Hour)}
+	if !e.fresh(0) {
+		t.Error("entry with no MaxAge and no TTL should be fresh")
+	}
+	if e.fresh(time.Minute) {
+		t.Error("entry older than the caller's TTL should not be fresh")
+	}
+}
+
+func TestCachingTransportHitAndRevalidate(t *testing.T) {
+	var hits int
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits++
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		w.Header().Set("Cache-Control", "max-age=300")
+		w.Write([]byte("body"))
+	}))
+	defer upstream.Close()
+
+	cache, err := NewCache(t.TempDir(), time.Minute, false)
+	if err != nil {
+		t.Fatalf("NewCache: %s", err)
+	}
+	client := &http.Client{Transport: &CachingTransport{Cache: cache}}
+
+	for i := 0; i < 3; i++ {
+		resp, err := client.Get(upstream.URL)
+		if err != nil {
+			t.Fatalf("GET #%d: %s", i, err)
+		}
+		resp.Body.Close()
+	}
+	if hits != 1 {
+		t.Errorf("fresh entry should serve from cache without hitting upstream again, got %d upstream hits", hits)
+	}
+	if stats := cache.Stats(); stats.Hits != 2 {
+		t.Errorf("want 2 cache hits, got %+v", stats)
+	}
+
+	revalidating, err := NewCache(t.TempDir(), 0, true)
+	if err != nil {
+		t.Fatalf("NewCache: %s", err)
+	}
+	client = &http.Client{Transport: &CachingTransport{Cache: revalidating}}
+	hits = 0
+	for i := 0; i < 2; i++ {
+		resp, err := client.Get(upstream.URL)
+		if err != nil {
+			t.Fatalf("GET #%d: %s", i, err)
+		}
+		resp.Body.Close()
+	}
+	if hits != 2 {
+		t.Errorf("Refresh=true should always revalidate, got %d upstream hits", hits)
+	}
+	if stats := revalidating.Stats(); stats.NotModified != 1 {
+		t.Errorf("want 1 not-modified response, got %+v", stats)
+	}
+}
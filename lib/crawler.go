@@ -0,0 +1,271 @@
+package lib
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// CrawlResult pairs one fetched page with its page number so results can
+// be reassembled in page order once every worker has finished.
+type CrawlResult struct {
+	Page  int
+	Users *SOUsers
+	Err   error
+}
+
+// lowQuotaRatio is the fraction of QuotaMax remaining below which Run
+// halves its worker count.
+const lowQuotaRatio = 0.2
+
+// defaultMaxPageRetries is how many times a worker retries a single page
+// fetch that errors before giving up on that page.
+const defaultMaxPageRetries = 3
+
+// Crawler fetches a bounded range of Stack Exchange user pages with a pool
+// of worker goroutines. It honors the API's backoff hint, throttles down
+// to half its workers once the quota runs low, and cancels the
+// outstanding workers through a shared context.Context as soon as a page
+// reports HasMore=false, quota runs out, or GetUserInfo signals the
+// reputation floor has been crossed.
+type Crawler struct {
+	BaseURL       string
+	Query         string
+	Key           string
+	Workers       int
+	MinReputation int
+
+	// ReqTimeout, when non-zero, bounds each individual page fetch via a
+	// DeadlineTimer-backed context derived from the Run context.
+	ReqTimeout time.Duration
+
+	// MaxPageRetries bounds how many times a worker retries a single page
+	// fetch that errors before giving up on it and letting Run advance
+	// past it. Zero means defaultMaxPageRetries.
+	MaxPageRetries int
+
+	// Progress, if set, is called from Run's single result-processing
+	// goroutine after each page lands.
+	Progress func(page, maxPages int)
+}
+
+// NewCrawler builds a Crawler dispatching against fmt.Sprintf(query, page)
+// relative to baseURL, using key (may be empty) and workers concurrent
+// fetchers.
+func NewCrawler(baseURL, query, key string, workers, minReputation int) *Crawler {
+	if workers < 1 {
+		workers = 1
+	}
+	return &Crawler{
+		BaseURL:       baseURL,
+		Query:         query,
+		Key:           key,
+		Workers:       workers,
+		MinReputation: minReputation,
+	}
+}
+
+// Run fetches pages 1..maxPages in parallel, feeds each page through
+// GetUserInfo in page order as soon as its predecessors have landed, and
+// returns the merged ranks (re-sorted by reputation to erase any residual
+// concurrency jitter) plus the last page actually requested.
+func (c *Crawler) Run(ctx context.Context, maxPages int, filter Filter, limit int, term bool) (ranks Ranks, lastPage int, err error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	jobs := make(chan int)
+	results := make(chan CrawlResult)
+
+	var wg sync.WaitGroup
+	var backoffMu sync.Mutex
+	var backoffUntil time.Time
+
+	// permits caps how many workers may fetch a page at once. It starts
+	// full so all c.Workers run concurrently; throttle() below drains it
+	// down when the quota runs low, without touching the worker pool
+	// itself.
+	permits := make(chan struct{}, c.Workers)
+	for i := 0; i < c.Workers; i++ {
+		permits <- struct{}{}
+	}
+
+	var throttled bool
+	throttle := func(users *SOUsers) {
+		if throttled || users.QuotaMax == 0 {
+			return
+		}
+		if float64(users.QuotaRemaining)/float64(users.QuotaMax) >= lowQuotaRatio {
+			return
+		}
+		throttled = true
+
+		reduce := c.Workers / 2
+		if reduce < 1 {
+			return
+		}
+		Warning.Printf("Quota low (%d/%d remaining), throttling down by %d workers\n",
+			users.QuotaRemaining, users.QuotaMax, reduce)
+		go func() {
+			for i := 0; i < reduce; i++ {
+				select {
+				case <-permits:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+
+	worker := func() {
+		defer wg.Done()
+		for page := range jobs {
+			select {
+			case <-permits:
+			case <-ctx.Done():
+				return
+			}
+
+			backoffMu.Lock()
+			wait := time.Until(backoffUntil)
+			backoffMu.Unlock()
+			if wait > 0 {
+				Info.Printf("Backing off for %s before page %d\n", wait, page)
+				select {
+				case <-time.After(wait):
+				case <-ctx.Done():
+					permits <- struct{}{}
+					return
+				}
+			}
+
+			url := fmt.Sprintf("%s/%s", c.BaseURL, fmt.Sprintf(c.Query, page))
+			if c.Key != "" {
+				url = fmt.Sprintf("%s&key=%s", url, c.Key)
+			}
+
+			maxRetries := c.MaxPageRetries
+			if maxRetries <= 0 {
+				maxRetries = defaultMaxPageRetries
+			}
+
+			users := new(SOUsers)
+			var fetchErr error
+			for attempt := 0; attempt <= maxRetries; attempt++ {
+				fetchCtx := ctx
+				var reqCancel context.CancelFunc
+				if c.ReqTimeout > 0 {
+					var dt DeadlineTimer
+					fetchCtx, reqCancel = WithDeadlineTimer(ctx, &dt, c.ReqTimeout)
+				}
+
+				fetchErr = StreamHTTPCtx(fetchCtx, url, users, true)
+				if reqCancel != nil {
+					reqCancel()
+				}
+				if fetchErr == nil {
+					break
+				}
+				if attempt < maxRetries {
+					Warning.Printf("Page %d: %s, retrying (%d/%d)\n", page, fetchErr, attempt+1, maxRetries)
+					select {
+					case <-time.After(time.Second):
+					case <-ctx.Done():
+						permits <- struct{}{}
+						return
+					}
+				}
+			}
+			if fetchErr != nil {
+				Warning.Printf("Page %d: giving up after %d retries: %s\n", page, maxRetries, fetchErr)
+			}
+			if fetchErr == nil && users.Backoff > 0 {
+				backoffMu.Lock()
+				backoffUntil = time.Now().Add(time.Duration(users.Backoff) * time.Second)
+				backoffMu.Unlock()
+			}
+
+			permits <- struct{}{}
+
+			select {
+			case results <- CrawlResult{Page: page, Users: users, Err: fetchErr}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}
+
+	wg.Add(c.Workers)
+	for i := 0; i < c.Workers; i++ {
+		go worker()
+	}
+
+	go func() {
+		defer close(jobs)
+		for page := 1; page <= maxPages; page++ {
+			select {
+			case jobs <- page:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	pending := make(map[int]*SOUsers)
+	next := 1
+	counter := 0
+	done := false
+
+	for res := range results {
+		if done {
+			continue
+		}
+
+		// A page that still errors after Run's worker-level retries is
+		// treated as satisfied with zero users, rather than left out of
+		// pending, so it can't permanently stall the next-pointer
+		// reassembly below for every later page that did succeed.
+		users := res.Users
+		if res.Err != nil {
+			users = &SOUsers{HasMore: true}
+		}
+
+		pending[res.Page] = users
+		for {
+			users, ok := pending[next]
+			if !ok {
+				break
+			}
+			delete(pending, next)
+			lastPage = next
+			next++
+
+			throttle(users)
+			if c.Progress != nil {
+				c.Progress(lastPage, maxPages)
+			}
+
+			repLimit := GetUserInfoCtx(ctx, users, c.MinReputation, filter, &counter, limit, &ranks, term)
+			if !repLimit || !users.HasMore || (users.QuotaMax != 0 && users.QuotaRemaining == 0) || next > maxPages {
+				done = true
+				cancel()
+				break
+			}
+		}
+	}
+
+	sort.SliceStable(ranks, func(i, j int) bool {
+		return ranks[i].Reputation > ranks[j].Reputation
+	})
+	for i := range ranks {
+		ranks[i].Rank = i + 1
+	}
+
+	return ranks, lastPage, nil
+}
@@ -0,0 +1,147 @@
+package lib
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync/atomic"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	Init(ioutil.Discard, ioutil.Discard, ioutil.Discard, ioutil.Discard)
+	os.Exit(m.Run())
+}
+
+func TestCrawlerRunReassemblesInOrder(t *testing.T) {
+	const maxPages = 5
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		page := r.URL.Query().Get("page")
+		fmt.Fprintf(w, `{"items":[{"user_id":%s,"reputation":1000,"display_name":"u%s"}],"has_more":%v,"quota_max":300,"quota_remaining":300}`,
+			page, page, page != fmt.Sprint(maxPages))
+	}))
+	defer upstream.Close()
+
+	c := NewCrawler(upstream.URL, "users?page=%d", "", 4, 0)
+	f, err := ParseFilter("")
+	if err != nil {
+		t.Fatalf("ParseFilter: %s", err)
+	}
+
+	ranks, lastPage, err := c.Run(context.Background(), maxPages, f, 0, false)
+	if err != nil {
+		t.Fatalf("Run: %s", err)
+	}
+	if lastPage != maxPages {
+		t.Errorf("lastPage = %d, want %d", lastPage, maxPages)
+	}
+	if len(ranks) != maxPages {
+		t.Fatalf("got %d ranks, want %d", len(ranks), maxPages)
+	}
+
+	seen := make(map[int]bool)
+	for _, r := range ranks {
+		seen[r.UserID] = true
+	}
+	for page := 1; page <= maxPages; page++ {
+		if !seen[page] {
+			t.Errorf("page %d's user missing from ranks", page)
+		}
+	}
+}
+
+func TestCrawlerRunSkipsPermanentlyFailedPage(t *testing.T) {
+	const maxPages = 5
+	const failPage = "2"
+
+	var requests int64
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&requests, 1)
+		page := r.URL.Query().Get("page")
+		if page == failPage {
+			http.Error(w, "boom", http.StatusInternalServerError)
+			return
+		}
+		fmt.Fprintf(w, `{"items":[{"user_id":%s,"reputation":1000,"display_name":"u%s"}],"has_more":%v,"quota_max":300,"quota_remaining":300}`,
+			page, page, page != fmt.Sprint(maxPages))
+	}))
+	defer upstream.Close()
+
+	c := NewCrawler(upstream.URL, "users?page=%d", "", 1, 0)
+	c.MaxPageRetries = 1
+
+	f, err := ParseFilter("")
+	if err != nil {
+		t.Fatalf("ParseFilter: %s", err)
+	}
+
+	ranks, lastPage, err := c.Run(context.Background(), maxPages, f, 0, false)
+	if err != nil {
+		t.Fatalf("Run: %s", err)
+	}
+	if lastPage != maxPages {
+		t.Fatalf("a single failed page should not stall reassembly: lastPage = %d, want %d", lastPage, maxPages)
+	}
+	if len(ranks) != maxPages-1 {
+		t.Fatalf("got %d ranks, want %d (every page but the failed one)", len(ranks), maxPages-1)
+	}
+	for _, r := range ranks {
+		if r.DisplayName == "u"+failPage {
+			t.Errorf("the failed page's user should not appear in ranks")
+		}
+	}
+}
+
+func TestCrawlerRunStopsOnQuotaExhausted(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"items":[{"user_id":1,"reputation":1000}],"has_more":true,"quota_max":300,"quota_remaining":0}`)
+	}))
+	defer upstream.Close()
+
+	c := NewCrawler(upstream.URL, "users?page=%d", "", 2, 0)
+	f, err := ParseFilter("")
+	if err != nil {
+		t.Fatalf("ParseFilter: %s", err)
+	}
+
+	_, lastPage, err := c.Run(context.Background(), 10, f, 0, false)
+	if err != nil {
+		t.Fatalf("Run: %s", err)
+	}
+	if lastPage != 1 {
+		t.Errorf("lastPage = %d, want 1 (run should stop once quota hits zero)", lastPage)
+	}
+}
+
+func TestCrawlerRunMinReputationStops(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		page := r.URL.Query().Get("page")
+		rep := 1000
+		if page == "2" {
+			rep = 10
+		}
+		fmt.Fprintf(w, `{"items":[{"user_id":%s,"reputation":%d}],"has_more":true,"quota_max":300,"quota_remaining":300}`, page, rep)
+	}))
+	defer upstream.Close()
+
+	c := NewCrawler(upstream.URL, "users?page=%d", "", 1, 500)
+	f, err := ParseFilter("")
+	if err != nil {
+		t.Fatalf("ParseFilter: %s", err)
+	}
+
+	ranks, lastPage, err := c.Run(context.Background(), 10, f, 0, false)
+	if err != nil {
+		t.Fatalf("Run: %s", err)
+	}
+	if lastPage != 2 {
+		t.Errorf("lastPage = %d, want 2 (run should stop once reputation drops below the floor)", lastPage)
+	}
+	if len(ranks) != 1 {
+		t.Errorf("got %d ranks, want 1", len(ranks))
+	}
+}
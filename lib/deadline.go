@@ -0,0 +1,117 @@
+package lib
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// DeadlineTimer implements a resettable, channel-based deadline, modeled on
+// the netstack pattern of keeping a cancelCh that closes when the deadline
+// fires (or is cancelled) alongside the *time.Timer driving it. Unlike
+// context.WithDeadline, the deadline can be moved forward or backward, or
+// cleared entirely, without discarding and recreating the cancellation
+// channel callers may already be selecting on.
+type DeadlineTimer struct {
+	mu       sync.Mutex
+	timer    *time.Timer
+	cancelCh chan struct{}
+}
+
+func (d *DeadlineTimer) init() {
+	if d.cancelCh == nil {
+		d.cancelCh = make(chan struct{})
+	}
+}
+
+// SetReadDeadline arms the timer to close Done() at t, or disarms it when t
+// is the zero time. A t already in the past closes Done() immediately.
+func (d *DeadlineTimer) SetReadDeadline(t time.Time) {
+	d.setDeadline(t)
+}
+
+// SetWriteDeadline is SetReadDeadline under another name: soranks only ever
+// waits on one thing at a time per DeadlineTimer, so there's no need to
+// track read and write deadlines separately.
+func (d *DeadlineTimer) SetWriteDeadline(t time.Time) {
+	d.setDeadline(t)
+}
+
+func (d *DeadlineTimer) setDeadline(t time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.init()
+
+	if d.timer != nil {
+		d.timer.Stop()
+		d.timer = nil
+	}
+
+	select {
+	case <-d.cancelCh:
+		d.cancelCh = make(chan struct{})
+	default:
+	}
+
+	if t.IsZero() {
+		return
+	}
+
+	dur := time.Until(t)
+	if dur <= 0 {
+		close(d.cancelCh)
+		return
+	}
+
+	cancelCh := d.cancelCh
+	d.timer = time.AfterFunc(dur, func() {
+		close(cancelCh)
+	})
+}
+
+// Done returns the channel that closes once the deadline fires or Cancel is
+// called.
+func (d *DeadlineTimer) Done() <-chan struct{} {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.init()
+	return d.cancelCh
+}
+
+// Cancel closes Done() immediately, as if the deadline had just fired.
+func (d *DeadlineTimer) Cancel() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.init()
+	select {
+	case <-d.cancelCh:
+	default:
+		close(d.cancelCh)
+	}
+}
+
+// WithDeadlineTimer derives a context from parent that is cancelled either
+// when parent is, or when d's deadline (armed here for timeout, or left
+// disarmed when timeout is zero) fires. It bridges DeadlineTimer into the
+// context-aware StreamHTTPCtx/GetUserInfoCtx call chain.
+func WithDeadlineTimer(parent context.Context, d *DeadlineTimer, timeout time.Duration) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(parent)
+
+	if timeout > 0 {
+		d.SetReadDeadline(time.Now().Add(timeout))
+	} else {
+		d.SetReadDeadline(time.Time{})
+	}
+
+	done := d.Done()
+	go func() {
+		select {
+		case <-done:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	return ctx, cancel
+}
@@ -0,0 +1,95 @@
+package lib
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestDeadlineTimerFiresAndResets(t *testing.T) {
+	var d DeadlineTimer
+
+	d.SetReadDeadline(time.Now().Add(20 * time.Millisecond))
+	select {
+	case <-d.Done():
+		t.Fatal("Done() closed before the deadline")
+	case <-time.After(5 * time.Millisecond):
+	}
+
+	d.SetReadDeadline(time.Now().Add(time.Hour))
+	select {
+	case <-d.Done():
+		t.Fatal("Done() should not close after the deadline was pushed back")
+	case <-time.After(30 * time.Millisecond):
+	}
+
+	d.SetReadDeadline(time.Time{})
+	select {
+	case <-d.Done():
+		t.Fatal("Done() should not close once the deadline is cleared")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	d.SetReadDeadline(time.Now().Add(10 * time.Millisecond))
+	select {
+	case <-d.Done():
+	case <-time.After(time.Second):
+		t.Fatal("Done() was not closed once the deadline passed")
+	}
+}
+
+func TestDeadlineTimerPastDeadlineClosesImmediately(t *testing.T) {
+	var d DeadlineTimer
+	d.SetReadDeadline(time.Now().Add(-time.Second))
+	select {
+	case <-d.Done():
+	default:
+		t.Fatal("a deadline already in the past should close Done() immediately")
+	}
+}
+
+func TestDeadlineTimerCancel(t *testing.T) {
+	var d DeadlineTimer
+	d.Cancel()
+	select {
+	case <-d.Done():
+	default:
+		t.Fatal("Cancel should close Done()")
+	}
+	d.Cancel()
+}
+
+func TestWithDeadlineTimer(t *testing.T) {
+	var d DeadlineTimer
+	ctx, cancel := WithDeadlineTimer(context.Background(), &d, 20*time.Millisecond)
+	defer cancel()
+
+	select {
+	case <-ctx.Done():
+		t.Fatal("context cancelled before the timeout")
+	case <-time.After(5 * time.Millisecond):
+	}
+
+	select {
+	case <-ctx.Done():
+	case <-time.After(time.Second):
+		t.Fatal("context was not cancelled once the timeout elapsed")
+	}
+	if ctx.Err() != context.Canceled {
+		t.Errorf("ctx.Err() = %v, want context.Canceled", ctx.Err())
+	}
+}
+
+func TestWithDeadlineTimerParentCancel(t *testing.T) {
+	var d DeadlineTimer
+	parent, parentCancel := context.WithCancel(context.Background())
+	ctx, cancel := WithDeadlineTimer(parent, &d, 0)
+	defer cancel()
+
+	parentCancel()
+	select {
+	case <-ctx.Done():
+	case <-time.After(time.Second):
+		t.Fatal("derived context was not cancelled when its parent was")
+	}
+}
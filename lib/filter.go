@@ -0,0 +1,377 @@
+package lib
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/scanner"
+)
+
+// Filter is a predicate over a single Stack Exchange user, evaluated
+// through the jsonq-style Query.
+type Filter func(q *Query) bool
+
+// And combines filters with a short-circuiting logical AND, the same way
+// the expression language's && operator does. It's how -location and
+// -filter are composed in app/soranks.go.
+func And(filters ...Filter) Filter {
+	return func(q *Query) bool {
+		for _, f := range filters {
+			if !f(q) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// fieldPaths maps the field names accepted by filter expressions to their
+// path through the JSON document produced by an SOUser, so "badges.gold"
+// reads naturally while walking "badge_counts.gold" underneath.
+var fieldPaths = map[string][]string{
+	"location":                {"location"},
+	"reputation":              {"reputation"},
+	"reputation_change_year":  {"reputation_change_year"},
+	"reputation_change_month": {"reputation_change_month"},
+	"reputation_change_week":  {"reputation_change_week"},
+	"reputation_change_day":   {"reputation_change_day"},
+	"is_employee":             {"is_employee"},
+	"badges.gold":             {"badge_counts", "gold"},
+	"badges.silver":           {"badge_counts", "silver"},
+	"badges.bronze":           {"badge_counts", "bronze"},
+}
+
+// ParseFilter compiles a -filter expression such as
+// `location ~= /spain/ && badges.gold >= 5 && is_employee == false` into a Filter.
+// An empty expression matches every user. Comparisons support ~= (regexp
+// match against a string field), ==, != (int or bool fields) and
+// >=, <=, >, < (int fields); terms combine with &&, ||, ! and parens.
+func ParseFilter(expr string) (Filter, error) {
+	if strings.TrimSpace(expr) == "" {
+		return func(*Query) bool { return true }, nil
+	}
+
+	toks, err := tokenizeFilter(expr)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &filterParser{toks: toks, expr: expr}
+	f, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos != len(p.toks) {
+		return nil, fmt.Errorf("filter: unexpected trailing input in %q", expr)
+	}
+	return f, nil
+}
+
+type filterTokenKind int
+
+const (
+	tokIdent filterTokenKind = iota
+	tokNumber
+	tokBool
+	tokRegex
+	tokOp
+	tokAnd
+	tokOr
+	tokNot
+	tokLParen
+	tokRParen
+)
+
+type filterToken struct {
+	kind filterTokenKind
+	text string
+}
+
+// tokenizeFilter lexes expr with text/scanner for identifiers and numbers,
+// handling the multi-character operators (&&, ||, ~=, ==, !=, >=, <=) and
+// /regexp/ literals by hand, since scanner.Scanner has no notion of either.
+func tokenizeFilter(expr string) ([]filterToken, error) {
+	var toks []filterToken
+
+	var sc scanner.Scanner
+	sc.Init(strings.NewReader(expr))
+	sc.Mode = scanner.ScanIdents | scanner.ScanInts | scanner.ScanFloats
+	sc.Error = func(*scanner.Scanner, string) {}
+
+	for {
+		r := sc.Scan()
+		if r == scanner.EOF {
+			break
+		}
+
+		switch r {
+		case scanner.Ident:
+			text := sc.TokenText()
+			for sc.Peek() == '.' {
+				sc.Next()
+				if sc.Scan() != scanner.Ident {
+					return nil, fmt.Errorf("filter: expected identifier after '.' in %q", expr)
+				}
+				text += "." + sc.TokenText()
+			}
+			switch text {
+			case "true", "false":
+				toks = append(toks, filterToken{kind: tokBool, text: text})
+			default:
+				toks = append(toks, filterToken{kind: tokIdent, text: text})
+			}
+
+		case scanner.Int, scanner.Float:
+			toks = append(toks, filterToken{kind: tokNumber, text: sc.TokenText()})
+
+		case '/':
+			var b strings.Builder
+			for {
+				c := sc.Next()
+				if c == scanner.EOF {
+					return nil, fmt.Errorf("filter: unterminated /regexp/ in %q", expr)
+				}
+				if c == '/' {
+					break
+				}
+				b.WriteRune(c)
+			}
+			toks = append(toks, filterToken{kind: tokRegex, text: b.String()})
+
+		case '&':
+			if sc.Peek() != '&' {
+				return nil, fmt.Errorf("filter: unexpected '&' in %q, did you mean '&&'?", expr)
+			}
+			sc.Next()
+			toks = append(toks, filterToken{kind: tokAnd})
+
+		case '|':
+			if sc.Peek() != '|' {
+				return nil, fmt.Errorf("filter: unexpected '|' in %q, did you mean '||'?", expr)
+			}
+			sc.Next()
+			toks = append(toks, filterToken{kind: tokOr})
+
+		case '!':
+			if sc.Peek() == '=' {
+				sc.Next()
+				toks = append(toks, filterToken{kind: tokOp, text: "!="})
+			} else {
+				toks = append(toks, filterToken{kind: tokNot})
+			}
+
+		case '=':
+			if sc.Peek() != '=' {
+				return nil, fmt.Errorf("filter: unexpected '=' in %q, did you mean '=='?", expr)
+			}
+			sc.Next()
+			toks = append(toks, filterToken{kind: tokOp, text: "=="})
+
+		case '~':
+			if sc.Peek() != '=' {
+				return nil, fmt.Errorf("filter: unexpected '~' in %q, did you mean '~='?", expr)
+			}
+			sc.Next()
+			toks = append(toks, filterToken{kind: tokOp, text: "~="})
+
+		case '>':
+			if sc.Peek() == '=' {
+				sc.Next()
+				toks = append(toks, filterToken{kind: tokOp, text: ">="})
+			} else {
+				toks = append(toks, filterToken{kind: tokOp, text: ">"})
+			}
+
+		case '<':
+			if sc.Peek() == '=' {
+				sc.Next()
+				toks = append(toks, filterToken{kind: tokOp, text: "<="})
+			} else {
+				toks = append(toks, filterToken{kind: tokOp, text: "<"})
+			}
+
+		case '(':
+			toks = append(toks, filterToken{kind: tokLParen})
+		case ')':
+			toks = append(toks, filterToken{kind: tokRParen})
+
+		default:
+			return nil, fmt.Errorf("filter: unexpected character %q in %q", string(r), expr)
+		}
+	}
+
+	return toks, nil
+}
+
+// filterParser is a recursive-descent parser over a pre-lexed token
+// stream: parseOr -> parseAnd -> parseUnary -> parseComparison/parens, so
+// && binds tighter than ||, and ! binds tighter than both.
+type filterParser struct {
+	toks []filterToken
+	pos  int
+	expr string
+}
+
+func (p *filterParser) peek() (filterToken, bool) {
+	if p.pos >= len(p.toks) {
+		return filterToken{}, false
+	}
+	return p.toks[p.pos], true
+}
+
+func (p *filterParser) next() (filterToken, bool) {
+	t, ok := p.peek()
+	if ok {
+		p.pos++
+	}
+	return t, ok
+}
+
+func (p *filterParser) parseOr() (Filter, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		t, ok := p.peek()
+		if !ok || t.kind != tokOr {
+			return left, nil
+		}
+		p.pos++
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		l, r := left, right
+		left = func(q *Query) bool { return l(q) || r(q) }
+	}
+}
+
+func (p *filterParser) parseAnd() (Filter, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		t, ok := p.peek()
+		if !ok || t.kind != tokAnd {
+			return left, nil
+		}
+		p.pos++
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		l, r := left, right
+		left = func(q *Query) bool { return l(q) && r(q) }
+	}
+}
+
+func (p *filterParser) parseUnary() (Filter, error) {
+	if t, ok := p.peek(); ok && t.kind == tokNot {
+		p.pos++
+		inner, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return func(q *Query) bool { return !inner(q) }, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *filterParser) parsePrimary() (Filter, error) {
+	t, ok := p.peek()
+	if !ok {
+		return nil, fmt.Errorf("filter: unexpected end of expression in %q", p.expr)
+	}
+
+	if t.kind == tokLParen {
+		p.pos++
+		inner, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		closing, ok := p.next()
+		if !ok || closing.kind != tokRParen {
+			return nil, fmt.Errorf("filter: missing ')' in %q", p.expr)
+		}
+		return inner, nil
+	}
+
+	return p.parseComparison()
+}
+
+func (p *filterParser) parseComparison() (Filter, error) {
+	pathTok, ok := p.next()
+	if !ok || pathTok.kind != tokIdent {
+		return nil, fmt.Errorf("filter: expected field name in %q", p.expr)
+	}
+	path, ok := fieldPaths[pathTok.text]
+	if !ok {
+		return nil, fmt.Errorf("filter: unknown field %q in %q", pathTok.text, p.expr)
+	}
+
+	opTok, ok := p.next()
+	if !ok || opTok.kind != tokOp {
+		return nil, fmt.Errorf("filter: expected comparison operator after %q in %q", pathTok.text, p.expr)
+	}
+
+	valTok, ok := p.next()
+	if !ok {
+		return nil, fmt.Errorf("filter: expected value after %q %s in %q", pathTok.text, opTok.text, p.expr)
+	}
+
+	switch opTok.text {
+	case "~=":
+		if valTok.kind != tokRegex {
+			return nil, fmt.Errorf("filter: %s expects a /regexp/ literal in %q", opTok.text, p.expr)
+		}
+		re, err := regexp.Compile(fmt.Sprintf("(?i)%s", valTok.text))
+		if err != nil {
+			return nil, fmt.Errorf("filter: bad regexp %q: %w", valTok.text, err)
+		}
+		return func(q *Query) bool { return re.MatchString(q.String(path...)) }, nil
+
+	case "==", "!=":
+		negate := opTok.text == "!="
+		switch valTok.kind {
+		case tokBool:
+			want := valTok.text == "true"
+			return func(q *Query) bool { return (q.Bool(path...) == want) != negate }, nil
+		case tokNumber:
+			want, err := strconv.Atoi(valTok.text)
+			if err != nil {
+				return nil, fmt.Errorf("filter: bad integer %q: %w", valTok.text, err)
+			}
+			return func(q *Query) bool { return (q.Int(path...) == want) != negate }, nil
+		default:
+			return nil, fmt.Errorf("filter: %s expects a boolean or integer in %q", opTok.text, p.expr)
+		}
+
+	case ">=", "<=", ">", "<":
+		if valTok.kind != tokNumber {
+			return nil, fmt.Errorf("filter: %s expects an integer in %q", opTok.text, p.expr)
+		}
+		want, err := strconv.Atoi(valTok.text)
+		if err != nil {
+			return nil, fmt.Errorf("filter: bad integer %q: %w", valTok.text, err)
+		}
+		op := opTok.text
+		return func(q *Query) bool {
+			got := q.Int(path...)
+			switch op {
+			case ">=":
+				return got >= want
+			case "<=":
+				return got <= want
+			case ">":
+				return got > want
+			default:
+				return got < want
+			}
+		}, nil
+	}
+
+	return nil, fmt.Errorf("filter: unsupported operator %q in %q", opTok.text, p.expr)
+}
@@ -0,0 +1,88 @@
+package lib
+
+import "testing"
+
+func queryFor(t *testing.T, user SOUser) *Query {
+	t.Helper()
+	q, err := NewQuery(user)
+	if err != nil {
+		t.Fatalf("NewQuery: %s", err)
+	}
+	return q
+}
+
+func TestParseFilterEmpty(t *testing.T) {
+	f, err := ParseFilter("")
+	if err != nil {
+		t.Fatalf("ParseFilter: %s", err)
+	}
+	if !f(queryFor(t, SOUser{})) {
+		t.Error("empty filter should match every user")
+	}
+}
+
+func TestParseFilterComparisons(t *testing.T) {
+	user := SOUser{Reputation: 12000, Location: "Madrid, Spain"}
+	user.BadgeCounts.Gold = 5
+
+	cases := []struct {
+		expr string
+		want bool
+	}{
+		{"reputation >= 10000", true},
+		{"reputation >= 20000", false},
+		{"reputation < 20000", true},
+		{"reputation == 12000", true},
+		{"reputation != 12000", false},
+		{"badges.gold >= 5", true},
+		{"location ~= /spain/", true},
+		{"location ~= /germany/", false},
+		{"reputation >= 10000 && badges.gold >= 5", true},
+		{"reputation >= 10000 && badges.gold >= 10", false},
+		{"reputation < 10000 || badges.gold >= 5", true},
+		{"!(reputation < 10000)", true},
+		{"(reputation >= 10000 && badges.gold >= 5) || location ~= /germany/", true},
+	}
+
+	for _, c := range cases {
+		f, err := ParseFilter(c.expr)
+		if err != nil {
+			t.Fatalf("ParseFilter(%q): %s", c.expr, err)
+		}
+		if got := f(queryFor(t, user)); got != c.want {
+			t.Errorf("ParseFilter(%q) = %v, want %v", c.expr, got, c.want)
+		}
+	}
+}
+
+func TestParseFilterErrors(t *testing.T) {
+	cases := []string{
+		"reputation >=",
+		"reputation >= /spain/",
+		"location ~= 5",
+		"unknown_field == 1",
+		"reputation >= 5 &",
+		"(reputation >= 5",
+		"reputation >= 5)",
+	}
+	for _, expr := range cases {
+		if _, err := ParseFilter(expr); err == nil {
+			t.Errorf("ParseFilter(%q) should have failed", expr)
+		}
+	}
+}
+
+func TestAnd(t *testing.T) {
+	always := func(*Query) bool { return true }
+	never := func(*Query) bool { return false }
+
+	if !And(always, always)(queryFor(t, SOUser{})) {
+		t.Error("And of two always-true filters should match")
+	}
+	if And(always, never)(queryFor(t, SOUser{})) {
+		t.Error("And should short-circuit to false when any filter rejects")
+	}
+	if !And()(queryFor(t, SOUser{})) {
+		t.Error("And with no filters should match everything")
+	}
+}
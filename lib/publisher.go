@@ -0,0 +1,241 @@
+package lib
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/v55/github"
+	"golang.org/x/oauth2"
+)
+
+const maxPublishRetries = 4
+
+// Committer identifies the author recorded on a published commit.
+type Committer struct {
+	Name  string
+	Email string
+}
+
+// PublishResult links back to whatever a Publisher created: the commit
+// holding the report, and optionally the pull request and/or release
+// opened from it.
+type PublishResult struct {
+	CommitURL      string
+	PullRequestURL string
+	ReleaseURL     string
+}
+
+// Publisher writes a rendered report out to wherever soranks publishes its
+// rankings.
+type Publisher interface {
+	Publish(ctx context.Context, path, content string) (*PublishResult, error)
+}
+
+// GitHubPublisher commits the report to Branch via the GitHub contents API,
+// optionally opening a pull request into PRBase and/or cutting a tagged
+// release whose body is the rendered report.
+type GitHubPublisher struct {
+	Owner  string
+	Repo   string
+	Branch string
+	Author Committer
+
+	PR      bool
+	PRBase  string
+	PRTitle string
+	PRBody  string
+
+	Release    bool
+	ReleaseTag string
+
+	client *github.Client
+}
+
+// NewGitHubPublisher builds a GitHubPublisher authenticated with the token
+// at tokenPath, falling back to the GITHUB_TOKEN environment variable.
+func NewGitHubPublisher(ctx context.Context, owner, repo, branch, tokenPath string, author Committer) (*GitHubPublisher, error) {
+	token, err := githubToken(tokenPath)
+	if err != nil {
+		return nil, err
+	}
+
+	ts := oauth2.StaticTokenSource(&oauth2.Token{AccessToken: token})
+	client := github.NewClient(oauth2.NewClient(ctx, ts))
+
+	return &GitHubPublisher{
+		Owner:  owner,
+		Repo:   repo,
+		Branch: branch,
+		Author: author,
+		client: client,
+	}, nil
+}
+
+func githubToken(tokenPath string) (string, error) {
+	if tokenPath != "" {
+		if b, err := ioutil.ReadFile(tokenPath); err == nil {
+			return strings.TrimRight(string(b), "\n"), nil
+		}
+	}
+	if token := os.Getenv("GITHUB_TOKEN"); token != "" {
+		return token, nil
+	}
+	return "", fmt.Errorf("no GitHub token at %q or $GITHUB_TOKEN", tokenPath)
+}
+
+// Publish creates or updates path on Branch with content, then opens a
+// pull request and/or cuts a release if GitHubPublisher is configured to.
+func (p *GitHubPublisher) Publish(ctx context.Context, path, content string) (*PublishResult, error) {
+	result := &PublishResult{}
+
+	existing, _, resp, err := p.client.Repositories.GetContents(ctx, p.Owner, p.Repo, path,
+		&github.RepositoryContentGetOptions{Ref: p.Branch})
+	logRateLimit(resp)
+	if err != nil && (resp == nil || resp.StatusCode != http.StatusNotFound) {
+		return nil, fmt.Errorf("check %s: %w", path, err)
+	}
+
+	opts := &github.RepositoryContentFileOptions{
+		Message: github.String(fmt.Sprintf("Update %s", path)),
+		Content: []byte(content),
+		Branch:  github.String(p.Branch),
+		Committer: &github.CommitAuthor{
+			Name:  github.String(p.Author.Name),
+			Email: github.String(p.Author.Email),
+		},
+	}
+	if existing != nil {
+		opts.SHA = existing.SHA
+	}
+
+	var commit *github.RepositoryContentResponse
+	err = retryOn5xx(ctx, func() (*github.Response, error) {
+		var apiResp *github.Response
+		var innerErr error
+		if existing != nil {
+			commit, apiResp, innerErr = p.client.Repositories.UpdateFile(ctx, p.Owner, p.Repo, path, opts)
+		} else {
+			commit, apiResp, innerErr = p.client.Repositories.CreateFile(ctx, p.Owner, p.Repo, path, opts)
+		}
+		return apiResp, innerErr
+	})
+	if err != nil {
+		return nil, fmt.Errorf("publish %s: %w", path, err)
+	}
+	if commit != nil && commit.Commit.HTMLURL != nil {
+		result.CommitURL = *commit.Commit.HTMLURL
+	}
+
+	if p.PR {
+		pr, err := p.openPR(ctx, path)
+		if err != nil {
+			return result, err
+		}
+		result.PullRequestURL = pr.GetHTMLURL()
+	}
+
+	if p.Release {
+		release, err := p.publishRelease(ctx, content)
+		if err != nil {
+			return result, err
+		}
+		result.ReleaseURL = release.GetHTMLURL()
+	}
+
+	return result, nil
+}
+
+func (p *GitHubPublisher) openPR(ctx context.Context, path string) (*github.PullRequest, error) {
+	title := p.PRTitle
+	if title == "" {
+		title = fmt.Sprintf("Update %s — %s", path, time.Now().Format("2006-01-02"))
+	}
+	body := p.PRBody
+	if body == "" {
+		body = fmt.Sprintf("Automated soranks publish of %s.", path)
+	}
+
+	var pr *github.PullRequest
+	err := retryOn5xx(ctx, func() (*github.Response, error) {
+		var apiResp *github.Response
+		var innerErr error
+		pr, apiResp, innerErr = p.client.PullRequests.Create(ctx, p.Owner, p.Repo, &github.NewPullRequest{
+			Title: github.String(title),
+			Head:  github.String(p.Branch),
+			Base:  github.String(p.PRBase),
+			Body:  github.String(body),
+		})
+		return apiResp, innerErr
+	})
+	if err != nil {
+		return nil, fmt.Errorf("open PR: %w", err)
+	}
+	return pr, nil
+}
+
+func (p *GitHubPublisher) publishRelease(ctx context.Context, body string) (*github.RepositoryRelease, error) {
+	tag := p.ReleaseTag
+	if tag == "" {
+		tag = fmt.Sprintf("soranks-%s", time.Now().Format("20060102-150405"))
+	}
+
+	var release *github.RepositoryRelease
+	err := retryOn5xx(ctx, func() (*github.Response, error) {
+		var apiResp *github.Response
+		var innerErr error
+		release, apiResp, innerErr = p.client.Repositories.CreateRelease(ctx, p.Owner, p.Repo, &github.RepositoryRelease{
+			TagName:         github.String(tag),
+			Name:            github.String(tag),
+			Body:            github.String(body),
+			TargetCommitish: github.String(p.Branch),
+		})
+		return apiResp, innerErr
+	})
+	if err != nil {
+		return nil, fmt.Errorf("publish release: %w", err)
+	}
+	return release, nil
+}
+
+// retryOn5xx calls fn, retrying with exponential backoff while the GitHub
+// API keeps failing with a 5xx response, and logging rate-limit info after
+// every attempt.
+func retryOn5xx(ctx context.Context, fn func() (*github.Response, error)) error {
+	var err error
+	for attempt := 0; attempt < maxPublishRetries; attempt++ {
+		var resp *github.Response
+		resp, err = fn()
+		logRateLimit(resp)
+		if err == nil {
+			return nil
+		}
+		if resp == nil || resp.StatusCode < 500 {
+			return err
+		}
+		if attempt == maxPublishRetries-1 {
+			break
+		}
+
+		wait := time.Duration(1<<uint(attempt)) * time.Second
+		Warning.Printf("GitHub %d, retrying in %s (attempt %d/%d)\n", resp.StatusCode, wait, attempt+1, maxPublishRetries)
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return err
+}
+
+func logRateLimit(resp *github.Response) {
+	if resp == nil {
+		return
+	}
+	Info.Printf("GitHub rate limit: %d/%d remaining, resets %s\n",
+		resp.Rate.Remaining, resp.Rate.Limit, resp.Rate.Reset.Format(time.RFC3339))
+}
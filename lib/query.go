@@ -0,0 +1,73 @@
+package lib
+
+import "encoding/json"
+
+// Query provides a jsonq-style path API over an arbitrary JSON-encodable
+// value, addressing a field by name without the caller needing to know its
+// concrete Go type.
+type Query struct {
+	data map[string]interface{}
+}
+
+// NewQuery marshals v to JSON and decodes the result into a
+// path-addressable Query.
+func NewQuery(v interface{}) (*Query, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	data := make(map[string]interface{})
+	if err := json.Unmarshal(b, &data); err != nil {
+		return nil, err
+	}
+
+	return &Query{data: data}, nil
+}
+
+// walk follows path through nested JSON objects, reporting false once a
+// key is missing or an intermediate value isn't an object.
+func (q *Query) walk(path ...string) (interface{}, bool) {
+	var cur interface{} = q.data
+	for _, key := range path {
+		obj, ok := cur.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		cur, ok = obj[key]
+		if !ok {
+			return nil, false
+		}
+	}
+	return cur, true
+}
+
+// String returns the string at path, or "" if it's absent or not a string.
+func (q *Query) String(path ...string) string {
+	v, ok := q.walk(path...)
+	if !ok {
+		return ""
+	}
+	s, _ := v.(string)
+	return s
+}
+
+// Int returns the int at path, or 0 if it's absent or not a number.
+func (q *Query) Int(path ...string) int {
+	v, ok := q.walk(path...)
+	if !ok {
+		return 0
+	}
+	f, _ := v.(float64)
+	return int(f)
+}
+
+// Bool returns the bool at path, or false if it's absent or not a bool.
+func (q *Query) Bool(path ...string) bool {
+	v, ok := q.walk(path...)
+	if !ok {
+		return false
+	}
+	b, _ := v.(bool)
+	return b
+}
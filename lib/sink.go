@@ -0,0 +1,141 @@
+package lib
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// Sink writes a rendered report out to wherever soranks publishes its
+// JSON and Markdown output, whether that's the local filesystem or an
+// S3-compatible object store. Put returns the URL (or path, for FileSink)
+// the content ended up at, so a caller can reference it elsewhere, e.g. in
+// a GitHub release body.
+type Sink interface {
+	Put(ctx context.Context, key string, content []byte, contentType string) (string, error)
+}
+
+// FileSink writes reports to the local filesystem, preserving soranks'
+// historical behavior of treating -jsonrsp/-mdrsp as plain file paths.
+type FileSink struct{}
+
+// Put writes content to key on the local filesystem, returning key itself
+// as its "URL".
+func (FileSink) Put(ctx context.Context, key string, content []byte, contentType string) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	if err := ioutil.WriteFile(key, content, 0o644); err != nil {
+		return "", err
+	}
+	return key, nil
+}
+
+// S3Sink uploads reports to an S3-compatible object store, maintaining a
+// stable "latest" alias alongside each dated object.
+type S3Sink struct {
+	Endpoint string
+	Bucket   string
+	Prefix   string
+	Public   bool
+
+	client *minio.Client
+}
+
+// NewS3Sink builds an S3Sink authenticated with accessKey/secretKey against
+// endpoint, talking to bucket under prefix. region may be empty for
+// endpoints that don't require one.
+func NewS3Sink(endpoint, region, bucket, prefix, accessKey, secretKey string, public bool) (*S3Sink, error) {
+	client, err := minio.New(endpoint, &minio.Options{
+		Creds:  credentials.NewStaticV4(accessKey, secretKey, ""),
+		Secure: true,
+		Region: region,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("can't set up S3 client: %w", err)
+	}
+
+	return &S3Sink{
+		Endpoint: endpoint,
+		Bucket:   bucket,
+		Prefix:   prefix,
+		Public:   public,
+		client:   client,
+	}, nil
+}
+
+// Put uploads content to a dated object under the sink's prefix, then
+// server-side copies it onto a stable "latest" alias with the same
+// extension, so a dashboard can always pull the latest report without
+// knowing the date it was published. It returns the dated object's URL.
+func (s *S3Sink) Put(ctx context.Context, key string, content []byte, contentType string) (string, error) {
+	dated := s.objectKey(datedKey(key))
+	latest := s.objectKey(latestKey(key))
+
+	putOpts := minio.PutObjectOptions{
+		ContentType:  contentType,
+		CacheControl: "public, max-age=300",
+	}
+	if s.Public {
+		// x-amz-acl is one of the headers minio-go recognizes by name in
+		// UserMetadata (see isAmzHeader) and passes through verbatim
+		// instead of prefixing with x-amz-meta-, so this sets the canned
+		// ACL rather than a piece of user metadata.
+		putOpts.UserMetadata = map[string]string{"x-amz-acl": "public-read"}
+	}
+
+	_, err := s.client.PutObject(ctx, s.Bucket, dated, bytes.NewReader(content), int64(len(content)), putOpts)
+	if err != nil {
+		return "", fmt.Errorf("upload %s: %w", dated, err)
+	}
+
+	dst := minio.CopyDestOptions{Bucket: s.Bucket, Object: latest}
+	src := minio.CopySrcOptions{Bucket: s.Bucket, Object: dated}
+	if _, err := s.client.CopyObject(ctx, dst, src); err != nil {
+		return "", fmt.Errorf("alias %s to %s: %w", dated, latest, err)
+	}
+
+	url := s.objectURL(dated)
+	Info.Printf("Uploaded %s\n", url)
+	return url, nil
+}
+
+// objectURL builds the HTTPS URL an uploaded object is reachable at.
+func (s *S3Sink) objectURL(key string) string {
+	return fmt.Sprintf("https://%s/%s/%s", s.Endpoint, s.Bucket, key)
+}
+
+// datedKey inserts today's date before key's extension, e.g. "ranks.json"
+// becomes "ranks-2026-07-25.json".
+func datedKey(key string) string {
+	ext := ""
+	base := key
+	if i := strings.LastIndex(key, "."); i >= 0 {
+		ext = key[i:]
+		base = key[:i]
+	}
+	return fmt.Sprintf("%s-%s%s", base, time.Now().Format("2006-01-02"), ext)
+}
+
+// latestKey replaces key's basename with "latest", keeping its extension,
+// e.g. "ranks.json" becomes "latest.json".
+func latestKey(key string) string {
+	ext := ""
+	if i := strings.LastIndex(key, "."); i >= 0 {
+		ext = key[i:]
+	}
+	return "latest" + ext
+}
+
+func (s *S3Sink) objectKey(key string) string {
+	if s.Prefix == "" {
+		return key
+	}
+	return strings.TrimRight(s.Prefix, "/") + "/" + key
+}
@@ -0,0 +1,349 @@
+// Package lib implements the Stack Exchange polling, ranking and
+// publishing primitives shared by the soranks command line tools.
+package lib
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"text/template"
+)
+
+// SOUsers mirrors the Stack Exchange /users response envelope.
+type SOUsers struct {
+	Items          []SOUser `json:"items"`
+	HasMore        bool     `json:"has_more"`
+	QuotaMax       int      `json:"quota_max"`
+	QuotaRemaining int      `json:"quota_remaining"`
+	Backoff        int      `json:"backoff,omitempty"`
+}
+
+// SOUser mirrors a single entry of the Stack Exchange /users response,
+// named (rather than left as an inline struct) so a Filter can be written
+// against it independently of SOUsers.
+type SOUser struct {
+	BadgeCounts struct {
+		Bronze int `json:"bronze"`
+		Silver int `json:"silver"`
+		Gold   int `json:"gold"`
+	} `json:"badge_counts"`
+	AccountID               int    `json:"account_id"`
+	IsEmployee              bool   `json:"is_employee"`
+	LastModifiedDate        int    `json:"last_modified_date"`
+	LastAccessDate          int    `json:"last_access_date"`
+	Age                     int    `json:"age,omitempty"`
+	ReputationChangeYear    int    `json:"reputation_change_year"`
+	ReputationChangeQuarter int    `json:"reputation_change_quarter"`
+	ReputationChangeMonth   int    `json:"reputation_change_month"`
+	ReputationChangeWeek    int    `json:"reputation_change_week"`
+	ReputationChangeDay     int    `json:"reputation_change_day"`
+	Reputation              int    `json:"reputation"`
+	CreationDate            int    `json:"creation_date"`
+	UserType                string `json:"user_type"`
+	UserID                  int    `json:"user_id"`
+	AcceptRate              int    `json:"accept_rate,omitempty"`
+	Location                string `json:"location,omitempty"`
+	WebsiteURL              string `json:"website_url,omitempty"`
+	Link                    string `json:"link"`
+	ProfileImage            string `json:"profile_image"`
+	DisplayName             string `json:"display_name"`
+}
+
+// SOUserRank is the flattened, ranked view of a single user written out
+// to the JSON and Markdown reports.
+type SOUserRank struct {
+	Rank         int      `json:"rank"`
+	AccountID    int      `json:"account_id"`
+	UserID       int      `json:"user_id"`
+	DisplayName  string   `json:"display_name"`
+	Reputation   int      `json:"reputation"`
+	Location     string   `json:"location,omitempty"`
+	WebsiteURL   string   `json:"website_url,omitempty"`
+	Link         string   `json:"link"`
+	ProfileImage string   `json:"profile_image"`
+	TopTags      []string `json:"top_tags,omitempty"`
+}
+
+// Ranks is an ordered collection of SOUserRank, best reputation first.
+type Ranks []SOUserRank
+
+var (
+	Trace   *log.Logger
+	Info    *log.Logger
+	Warning *log.Logger
+	Error   *log.Logger
+)
+
+// Init wires up the package level loggers, one per severity.
+func Init(
+	traceHandle io.Writer,
+	infoHandle io.Writer,
+	warningHandle io.Writer,
+	errorHandle io.Writer) {
+
+	Trace = log.New(traceHandle,
+		"TRACE: ",
+		log.Ldate|log.Ltime|log.Lshortfile)
+
+	Info = log.New(infoHandle,
+		"INFO: ",
+		log.Ldate|log.Ltime|log.Lshortfile)
+
+	Warning = log.New(warningHandle,
+		"WARN: ",
+		log.Ldate|log.Ltime|log.Lshortfile)
+
+	Error = log.New(errorHandle,
+		"ERROR: ",
+		log.Ldate|log.Ltime|log.Lshortfile)
+}
+
+// Decode reads a single SOUsers JSON document from r.
+func Decode(r io.Reader) (users *SOUsers, err error) {
+	users = new(SOUsers)
+	return users, json.NewDecoder(r).Decode(users)
+}
+
+// StreamHTTP fetches url and decodes the response body into v, transparently
+// inflating a gzip-encoded response when gzipEnabled requests one.
+func StreamHTTP(url string, v interface{}, gzipEnabled bool) (err error) {
+	return StreamHTTPCtx(context.Background(), url, v, gzipEnabled)
+}
+
+// StreamHTTPCtx is StreamHTTP with an explicit context, so a per-request
+// timeout or an operator-triggered shutdown can abort an in-flight fetch.
+func StreamHTTPCtx(ctx context.Context, url string, v interface{}, gzipEnabled bool) (err error) {
+	var reader io.ReadCloser
+
+	Trace.Println(url)
+
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		Trace.Println(err)
+		return err
+	}
+
+	if gzipEnabled {
+		req.Header.Set("Accept-Encoding", "gzip")
+	}
+
+	response, err := httpClient.Do(req)
+	if err != nil {
+		Trace.Println(err)
+		return err
+	}
+	defer response.Body.Close()
+
+	switch response.Header.Get("Content-Encoding") {
+	case "gzip":
+		reader, err = gzip.NewReader(response.Body)
+		if err != nil {
+			Trace.Println(err)
+			return err
+		}
+		defer reader.Close()
+	default:
+		reader = response.Body
+	}
+
+	return json.NewDecoder(reader).Decode(v)
+}
+
+// StreamFile decodes a SOUsers document previously dumped to disk, used
+// to replay a capture without spending API quota.
+func StreamFile(jsonfile string) (users *SOUsers, err error) {
+	return StreamFileCtx(context.Background(), jsonfile)
+}
+
+// StreamFileCtx is StreamFile with an explicit context, checked before the
+// file is opened so a cancelled run doesn't bother touching disk.
+func StreamFileCtx(ctx context.Context, jsonfile string) (users *SOUsers, err error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	reader, err := os.Open(jsonfile)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return Decode(reader)
+}
+
+// GetUserInfo walks a page of SOUsers, keeping the ones matching filter
+// and at or above minReputation, appending them to ranks until limit is
+// reached. It returns false once minReputation or limit cuts the scan
+// short, signalling the caller to stop requesting further pages.
+func GetUserInfo(users *SOUsers, minReputation int, filter Filter, counter *int, limit int, ranks *Ranks, term bool) (rep bool) {
+	return GetUserInfoCtx(context.Background(), users, minReputation, filter, counter, limit, ranks, term)
+}
+
+// GetUserInfoCtx is GetUserInfo with an explicit context, checked between
+// users so a cancelled run stops scanning the current page promptly
+// instead of only between pages.
+func GetUserInfoCtx(ctx context.Context, users *SOUsers, minReputation int, filter Filter, counter *int, limit int, ranks *Ranks, term bool) (rep bool) {
+	for _, user := range users.Items {
+		if ctx.Err() != nil {
+			return false
+		}
+		if user.Reputation < minReputation {
+			return false
+		}
+
+		q, err := NewQuery(user)
+		if err != nil {
+			Warning.Printf("Can't query user %s: %s\n", user.DisplayName, err)
+			continue
+		}
+
+		if filter(q) {
+			*counter += 1
+			if *counter == 1 && term {
+				Info.Println("User data:")
+				Info.Printf("%4s %-30s %6s %s\n", "Rank", "Name", "Rep", "Location")
+			}
+
+			s := SOUserRank{Rank: *counter,
+				AccountID:    user.AccountID,
+				UserID:       user.UserID,
+				DisplayName:  user.DisplayName,
+				Reputation:   user.Reputation,
+				Location:     user.Location,
+				WebsiteURL:   user.WebsiteURL,
+				Link:         user.Link,
+				ProfileImage: user.ProfileImage}
+
+			*ranks = append(*ranks, s)
+
+			if term {
+				Info.Printf("%4d %-30s %6d %s\n", *counter, html.UnescapeString(user.DisplayName),
+					user.Reputation, html.UnescapeString(user.Location))
+			}
+
+			if *counter >= limit && limit != 0 {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// DumpJson writes ranks as indented JSON to path on the local filesystem.
+func DumpJson(path *string, ranks *Ranks) {
+	if err := DumpJsonSink(context.Background(), FileSink{}, *path, ranks); err != nil {
+		panic(err)
+	}
+}
+
+// DumpJsonSink renders ranks as indented JSON and writes it to key via sink,
+// so the same report can be dropped onto disk or uploaded to S3/MinIO.
+func DumpJsonSink(ctx context.Context, sink Sink, key string, ranks *Ranks) error {
+	Trace.Printf("Writing JSON to: %s\n", key)
+
+	body, err := json.MarshalIndent(*ranks, "", " ")
+	if err != nil {
+		return err
+	}
+
+	if _, err := sink.Put(ctx, key, body, "application/json"); err != nil {
+		return err
+	}
+
+	Trace.Printf("Wrote %d bytes to %s\n", len(body), key)
+	return nil
+}
+
+// DumpMarkdown renders ranks as a Markdown table to path on the local
+// filesystem, headed by the location pattern used to produce them.
+func DumpMarkdown(path *string, ranks Ranks, location *string) {
+	if _, _, err := DumpMarkdownSink(context.Background(), FileSink{}, *path, ranks, location); err != nil {
+		panic(err)
+	}
+}
+
+// DumpMarkdownSink renders ranks as a Markdown table, writes it to key via
+// sink, and returns the rendered content plus the sink URL it landed at, so
+// callers (such as a GitHub publish step) can reuse the content without
+// reading it back from the sink and can reference where it was uploaded.
+func DumpMarkdownSink(ctx context.Context, sink Sink, key string, ranks Ranks, location *string) ([]byte, string, error) {
+	Trace.Printf("Writing MD to: %s\n", key)
+
+	head := `# soranks
+
+[Stackoverflow](http://stackoverflow.com/) rankings by **location**.
+
+### Area%s
+
+
+Rank|Name|Rep|Location|Web|Avatar|Tags
+----|----|---|--------|---|------|----
+`
+	var fmtLocation string
+
+	if *location == "." {
+		fmtLocation = ": WorldWide"
+	} else {
+		fmtLocation = fmt.Sprintf(" *pattern*: %s", *location)
+	}
+
+	userfmt := "{{.Rank}}|[{{.DisplayName}}]({{.Link}})|{{.Reputation}}|{{.Location}}|{{.WebsiteURL}}|![Avatar]({{.ProfileImage}})|{{tagLinks .TopTags}}\n"
+
+	var buf bytes.Buffer
+	buf.WriteString(fmt.Sprintf(head, fmtLocation))
+
+	tmpl, err := template.New("Ranking").Funcs(template.FuncMap{"tagLinks": tagLinks}).Parse(userfmt)
+	if err != nil {
+		return nil, "", err
+	}
+	for _, userRank := range ranks {
+		if err := tmpl.Execute(&buf, userRank); err != nil {
+			return nil, "", err
+		}
+	}
+
+	url, err := sink.Put(ctx, key, buf.Bytes(), "text/markdown; charset=utf-8")
+	if err != nil {
+		return nil, "", err
+	}
+
+	Trace.Printf("Wrote %d bytes to %s\n", buf.Len(), key)
+	return buf.Bytes(), url, nil
+}
+
+// tagLinks renders a user's top answer tags as comma-joined Markdown links
+// to the corresponding Stack Overflow tag page.
+func tagLinks(tags []string) string {
+	links := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		links = append(links, fmt.Sprintf("[%s](https://stackoverflow.com/questions/tagged/%s)", tag, tag))
+	}
+	return strings.Join(links, ", ")
+}
+
+// GetKey reads the Stack Exchange API key stored at path, returning an
+// empty string (and an unauthenticated, lower quota request) when it is
+// absent.
+func GetKey(path string) (key string) {
+	_, err := os.Stat(path)
+	if err != nil {
+		Warning.Printf("Can't find API key: %s", path)
+		return ""
+	}
+
+	strkey, err := ioutil.ReadFile(path)
+	if err != nil {
+		Warning.Printf("Can't load API key: %s", err)
+		return ""
+	}
+
+	return strings.TrimRight(string(strkey)[:], "\n")
+}
@@ -0,0 +1,129 @@
+package lib
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultAPIURL is the Stack Exchange API root used for ad-hoc calls,
+	// such as the per-user top answer tags pass, that aren't routed
+	// through a Crawler.
+	DefaultAPIURL = "https://api.stackexchange.com/2.2"
+
+	// SOUserTagsQuery fetches a user's top answer tags, most used first.
+	SOUserTagsQuery = `users/%d/top-answer-tags?page=1&pagesize=3&site=stackoverflow`
+)
+
+// SOTopTags mirrors the Stack Exchange /users/{id}/top-answer-tags response
+// envelope.
+type SOTopTags struct {
+	Items []struct {
+		TagName string `json:"tag_name"`
+	} `json:"items"`
+	HasMore        bool `json:"has_more"`
+	QuotaMax       int  `json:"quota_max"`
+	QuotaRemaining int  `json:"quota_remaining"`
+	Backoff        int  `json:"backoff,omitempty"`
+}
+
+// FetchTopTags fetches the top answer tags for the Stack Exchange user
+// identified by userID.
+func FetchTopTags(userID int, key string) ([]string, error) {
+	return FetchTopTagsCtx(context.Background(), userID, key)
+}
+
+// FetchTopTagsCtx is FetchTopTags with an explicit context, so a cancelled
+// run aborts the fetch mid-flight.
+func FetchTopTagsCtx(ctx context.Context, userID int, key string) ([]string, error) {
+	tags, _, err := fetchTopTags(ctx, userID, key)
+	return tags, err
+}
+
+// fetchTopTags is FetchTopTagsCtx that also returns the backoff, in
+// seconds, the API asked for, so EnrichTopTags can honor it.
+func fetchTopTags(ctx context.Context, userID int, key string) ([]string, int, error) {
+	url := fmt.Sprintf("%s/%s", DefaultAPIURL, fmt.Sprintf(SOUserTagsQuery, userID))
+	if key != "" {
+		url = fmt.Sprintf("%s&key=%s", url, key)
+	}
+
+	resp := new(SOTopTags)
+	if err := StreamHTTPCtx(ctx, url, resp, true); err != nil {
+		return nil, 0, err
+	}
+
+	tags := make([]string, 0, len(resp.Items))
+	for _, item := range resp.Items {
+		tags = append(tags, item.TagName)
+	}
+	return tags, resp.Backoff, nil
+}
+
+// EnrichTopTags fetches and attaches each rank's top answer tags in place,
+// using a bounded worker pool with the same backoff handling as Crawler.
+func EnrichTopTags(ctx context.Context, ranks Ranks, key string, workers int, term bool) {
+	if workers < 1 {
+		workers = 1
+	}
+
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	var backoffMu sync.Mutex
+	var backoffUntil time.Time
+
+	worker := func() {
+		defer wg.Done()
+		for i := range jobs {
+			backoffMu.Lock()
+			wait := time.Until(backoffUntil)
+			backoffMu.Unlock()
+			if wait > 0 {
+				select {
+				case <-time.After(wait):
+				case <-ctx.Done():
+					return
+				}
+			}
+
+			tags, backoff, err := fetchTopTags(ctx, ranks[i].UserID, key)
+			if err != nil {
+				Warning.Printf("Can't fetch tags for %s: %s\n", ranks[i].DisplayName, err)
+				continue
+			}
+			if backoff > 0 {
+				backoffMu.Lock()
+				backoffUntil = time.Now().Add(time.Duration(backoff) * time.Second)
+				backoffMu.Unlock()
+			}
+			ranks[i].TopTags = tags
+
+			if term {
+				Info.Printf("%4d %-30s %s\n", ranks[i].Rank,
+					html.UnescapeString(ranks[i].DisplayName), strings.Join(tags, ", "))
+			}
+		}
+	}
+
+	wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go worker()
+	}
+
+	go func() {
+		defer close(jobs)
+		for i := range ranks {
+			select {
+			case jobs <- i:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	wg.Wait()
+}